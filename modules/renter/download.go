@@ -0,0 +1,85 @@
+package renter
+
+import (
+	"sync"
+	"time"
+)
+
+// download contains all of the state for a single download as dispatched by
+// the renter. A download can span multiple chunks, each tracked by its own
+// unfinishedDownloadChunk, all of which share the fields below to report
+// progress and completion back to the caller.
+type download struct {
+	// Progress variables.
+	atomicDataReceived uint64
+
+	// Timing variables.
+	endTime time.Time
+
+	// Other progress variables.
+	chunksRemaining int
+	completeChan    chan struct{} // Closed once the download has fully completed.
+	err             error
+
+	// cancelChan is closed exactly once, whether the download finishes,
+	// fails, or is explicitly cancelled through the API. Every path that
+	// delivers a piece to a chunk of this download must select on
+	// cancelChan before touching the chunk's mutex, so that a host
+	// replying long after the chunk has already been recovered or failed
+	// cannot block on, or wake up, a chunk that has already been torn
+	// down.
+	cancelChan chan struct{}
+	cancelOnce sync.Once
+
+	destination downloadDestination // Where to write the downloaded data.
+
+	// staticStrategyID is the DownloadStrategy requested for this download,
+	// set from the download request's strategy field (or the renter API's
+	// strategy header, once the API layer maps it onto the request - not
+	// present in this package). Each chunk of the download consults it via
+	// unfinishedDownloadChunk.managedDownloadStrategy unless the chunk has
+	// its own staticDownloadStrategy set directly.
+	staticStrategyID downloadStrategyID
+
+	// staticRenter is nil-checked before use so that tests can build a
+	// download without standing up a full Renter.
+	staticRenter *Renter
+
+	mu sync.Mutex
+}
+
+// managedFail marks the download as having failed with the provided error,
+// if it has not already completed, and cancels it so that any remaining
+// in-flight pieces stop touching the download's chunks.
+func (d *download) managedFail(err error) {
+	d.mu.Lock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.mu.Unlock()
+	d.managedCancel()
+}
+
+// managedCancel closes cancelChan exactly once. Workers delivering pieces
+// for this download select on cancelChan so that they unwind instead of
+// operating on a chunk that may already be gone.
+func (d *download) managedCancel() {
+	d.cancelOnce.Do(func() {
+		close(d.cancelChan)
+	})
+}
+
+// newDownload initializes a download with its cancellation and completion
+// channels ready to use. r is the renter dispatching the download, and is
+// threaded down to each chunk so that chunk completion can notify the
+// renter's chunk prefetcher. strategyID is the DownloadStrategy requested
+// for this download (e.g. from the download request or the renter API's
+// strategy header); an empty strategyID falls back to StrategyFastest.
+func newDownload(r *Renter, strategyID downloadStrategyID) *download {
+	return &download{
+		completeChan:     make(chan struct{}),
+		cancelChan:       make(chan struct{}),
+		staticRenter:     r,
+		staticStrategyID: strategyID,
+	}
+}
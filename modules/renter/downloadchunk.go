@@ -1,7 +1,7 @@
 package renter
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -34,6 +34,7 @@ type unfinishedDownloadChunk struct {
 	masterKey   crypto.TwofishKey
 
 	// Fetch + Write instructions - read only or otherwise thread safe.
+	staticSiaPath     modules.SiaPath                            // Identifies the file this chunk belongs to, for prefetch bookkeeping.
 	staticChunkIndex  uint64                                     // Required for deriving the encryption keys for each piece.
 	staticChunkMap    map[types.FileContractID]downloadPieceInfo // Maps from file contract ids to the info for the piece associated with that contract
 	staticChunkSize   uint64
@@ -43,20 +44,33 @@ type unfinishedDownloadChunk struct {
 	staticWriteOffset int64 // Offet within the writer to write the completed data.
 
 	// Fetch + Write instructions - read only or otherwise thread safe.
-	staticLatencyTarget uint64
-	staticNeedsMemory   bool // Set to true if memory was not pre-allocated for this chunk.
-	staticOverdrive     int
-	staticPriority      uint64
+	staticLatencyTarget    uint64           // Consulted by DownloadStrategy.managedShouldEscalate to decide when to launch an overdrive piece.
+	staticNeedsMemory      bool             // Set to true if memory was not pre-allocated for this chunk.
+	staticOverdrive        int              // Maximum number of extra pieces in flight beyond the erasure code's minimum; enforced by shouldEscalateForOverdrive regardless of strategy.
+	staticPriority         uint64           // Consulted by strategyCheapest to shrink its effective latency target for urgent chunks.
+	staticDownloadStrategy DownloadStrategy // Controls standby worker selection and overdrive escalation.
+
+	// atomicChunkCancelled is set to 1 as soon as the chunk has failed or
+	// started recovering - not once recovery finishes - so that
+	// managedPieceDeliveryCancelled can be checked by a delivering worker
+	// without acquiring udc.mu, and so a piece that arrives after recovery
+	// has already claimed physicalChunkData bails out before touching it
+	// rather than racing recovery or its post-recovery cleanup. It is a
+	// separate signal from udc.download.cancelChan because a chunk can be
+	// torn down (failed, or recovered via an early overdrive piece) while
+	// the rest of the download - and its other chunks - keep running.
+	atomicChunkCancelled uint32
 
 	// Download chunk state - need mutex to access.
-	failed            bool      // Indicates if the chunk has been marked as failed.
-	physicalChunkData [][]byte  // Used to recover the logical data.
-	pieceUsage        []bool    // Which pieces are being actively fetched.
-	piecesCompleted   int       // Number of pieces that have successfully completed.
-	piecesRegistered  int       // Number of pieces that workers are actively fetching.
-	recoveryComplete  bool      // Whether or not the recovery has completed and the chunk memory released.
-	workersRemaining  int       // Number of workers still able to fetch the chunk.
-	workersStandby    []*worker // Set of workers that are able to work on this download, but are not needed unless other workers fail.
+	failed            bool                 // Indicates if the chunk has been marked as failed.
+	physicalChunkData [][]byte             // Used to recover the logical data.
+	pieceUsage        []bool               // Which pieces are being actively fetched.
+	pieceCancellers   []context.CancelFunc // Cancels the in-flight fetch for the piece at the same index, if any.
+	piecesCompleted   int                  // Number of pieces that have successfully completed.
+	piecesRegistered  int                  // Number of pieces that workers are actively fetching.
+	recoveryComplete  bool                 // Whether or not the recovery has completed and the chunk memory released.
+	workersRemaining  int                  // Number of workers still able to fetch the chunk.
+	workersStandby    []*worker            // Set of workers that are able to work on this download, but are not needed unless other workers fail.
 
 	// Memory management variables.
 	memoryAllocated uint64
@@ -72,16 +86,281 @@ type unfinishedDownloadChunk struct {
 func (udc *unfinishedDownloadChunk) fail(err error) {
 	udc.failed = true
 	udc.recoveryComplete = true
+	atomic.StoreUint32(&udc.atomicChunkCancelled, 1)
 	for i := range udc.physicalChunkData {
 		udc.physicalChunkData[i] = nil
 	}
 	udc.download.managedFail(fmt.Errorf("chunk %v failed", udc.staticChunkIndex))
 }
 
+// managedPieceDeliveryCancelled reports whether this chunk, or its download
+// as a whole, has already finished, failed, or been cancelled. It takes no
+// lock itself, so it is safe to call both before acquiring udc.mu (to avoid
+// blocking on, or mutating, state that has already been torn down when a
+// host replies long after the chunk was recovered) and again immediately
+// after acquiring it, to close the gap between the two: a chunk can recover
+// in between the two checks, and threadedRecoverLogicalData reads
+// physicalChunkData without udc.mu held once recovery is past the decrypt
+// step, so only the locked re-check is guaranteed to see that in time.
+func (udc *unfinishedDownloadChunk) managedPieceDeliveryCancelled() bool {
+	if atomic.LoadUint32(&udc.atomicChunkCancelled) == 1 {
+		return true
+	}
+	select {
+	case <-udc.download.cancelChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// managedRegisterPieceFetch creates a cancellable context for the piece
+// fetch about to be launched at pieceIndex and stores its cancel func
+// alongside pieceUsage, so that the fetch can be torn down early if the
+// chunk recovers before the piece arrives. The worker driving the fetch is
+// expected to pass the returned context down into its RPC calls and to
+// clear the piece's cancel func (via managedUnregisterPieceFetch) once the
+// fetch returns.
+func (udc *unfinishedDownloadChunk) managedRegisterPieceFetch(pieceIndex int) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	udc.mu.Lock()
+	// pieceCancellers is sized lazily, alongside pieceUsage, the first time
+	// a piece fetch is registered; there is no separate constructor for
+	// unfinishedDownloadChunk that allocates it up front.
+	if len(udc.pieceCancellers) != len(udc.pieceUsage) {
+		udc.pieceCancellers = make([]context.CancelFunc, len(udc.pieceUsage))
+	}
+	udc.pieceCancellers[pieceIndex] = cancel
+	udc.mu.Unlock()
+	return ctx
+}
+
+// managedUnregisterPieceFetch clears the cancel func for a piece fetch that
+// has returned, successfully or not.
+func (udc *unfinishedDownloadChunk) managedUnregisterPieceFetch(pieceIndex int) {
+	udc.mu.Lock()
+	udc.pieceCancellers[pieceIndex] = nil
+	udc.mu.Unlock()
+}
+
+// cancelOutstandingPieces cancels every piece fetch that is still
+// registered as in-flight. It is called once enough pieces have completed
+// to recover the chunk, so that overdrive fetches which are no longer
+// needed are torn down immediately instead of running to completion and
+// wasting host bandwidth and contract spending. udc.mu must be held by the
+// caller.
+func (udc *unfinishedDownloadChunk) cancelOutstandingPieces() {
+	for i, cancel := range udc.pieceCancellers {
+		if cancel == nil {
+			continue
+		}
+		cancel()
+		udc.pieceCancellers[i] = nil
+		// The piece's memory was reserved as part of piecesRegistered
+		// bookkeeping; mark it no longer in use so cleanUp can release it
+		// once the worker acknowledges the cancellation.
+		udc.pieceUsage[i] = false
+	}
+}
+
+// threadedDispatchChunk puts this chunk's standby workers to work fetching
+// pieces, one at a time, until enough pieces are in flight or completed to
+// recover the chunk. It is spawned once per chunk by the download dispatch
+// code after a chunk has been built and its workers assigned - the
+// goroutine-per-chunk lifecycle mirrors threadedRecoverLogicalData at the
+// other end. This is the call path managedSpawnPieceFetch,
+// managedRegisterPieceFetch, and (through threadedRecoverLogicalData)
+// cancelOutstandingPieces are designed to run under.
+func (udc *unfinishedDownloadChunk) threadedDispatchChunk() {
+	strategy := udc.managedDownloadStrategy()
+	start := time.Now()
+
+	udc.mu.Lock()
+	standby := strategy.managedSelectStandbyWorkers(udc, time.Since(start))
+	minPieces := udc.erasureCode.MinPieces()
+	udc.mu.Unlock()
+
+	for i, w := range standby {
+		if i >= len(udc.pieceUsage) {
+			// Never try more workers than the chunk has pieces; beyond that
+			// point no amount of escalation can produce a piece to fetch.
+			break
+		}
+		udc.managedSpawnPieceFetch(w)
+
+		udc.mu.Lock()
+		complete := udc.piecesCompleted >= minPieces || udc.failed
+		udc.mu.Unlock()
+		if complete {
+			break
+		}
+		if !strategy.managedShouldEscalate(udc, time.Since(start)) {
+			break
+		}
+	}
+}
+
+// managedDownloadStrategy returns the DownloadStrategy this chunk should
+// use: the chunk's own staticDownloadStrategy if the caller set one
+// explicitly, otherwise the strategy requested on the download as a whole
+// (via the download request / renter API strategy header, threaded through
+// download.staticStrategyID), falling back to StrategyFastest so a chunk
+// built without either still behaves like the renter always has.
+func (udc *unfinishedDownloadChunk) managedDownloadStrategy() DownloadStrategy {
+	if udc.staticDownloadStrategy != nil {
+		return udc.staticDownloadStrategy
+	}
+	if udc.download != nil {
+		return downloadStrategyForID(udc.download.staticStrategyID)
+	}
+	return strategyFastest{}
+}
+
+// managedSpawnPieceFetch launches the fetch for a single piece against
+// worker w, using the erasure-code piece index staticChunkMap associates
+// with w's host contract - not w's position in the standby set, which need
+// not match (strategyCheapest, for one, reorders standby workers by host
+// price). It registers a cancellable context for the fetch before starting
+// it, so that cancelOutstandingPieces can tear it down once enough other
+// pieces have arrived to recover the chunk, and clears that registration
+// once the fetch returns.
+func (udc *unfinishedDownloadChunk) managedSpawnPieceFetch(w *worker) {
+	pieceInfo := udc.staticChunkMap[w.staticHostFCID]
+	pieceIndex := int(pieceInfo.index)
+
+	udc.mu.Lock()
+	udc.pieceUsage[pieceIndex] = true
+	udc.piecesRegistered++
+	udc.mu.Unlock()
+
+	ctx := udc.managedRegisterPieceFetch(pieceIndex)
+	go func() {
+		defer udc.managedUnregisterPieceFetch(pieceIndex)
+		data, err := w.managedDownloadPiece(ctx, pieceInfo)
+
+		// managedPieceDeliveryCancelled is checked again below, right
+		// after udc.mu is acquired: the cheap, lock-free check here only
+		// saves a pointless lock/unlock round trip for the common case of
+		// a fetch that was already cancelled well before it returned. It
+		// cannot by itself rule out the chunk recovering, and
+		// threadedRecoverLogicalData reading physicalChunkData without
+		// udc.mu held, in the gap between this check and the lock below -
+		// only the locked re-check closes that race.
+		if udc.managedPieceDeliveryCancelled() {
+			udc.mu.Lock()
+			udc.piecesRegistered--
+			udc.mu.Unlock()
+			return
+		}
+
+		udc.mu.Lock()
+		defer udc.mu.Unlock()
+		udc.piecesRegistered--
+		if udc.managedPieceDeliveryCancelled() {
+			// The chunk recovered, failed, or the download was cancelled
+			// between the check above and acquiring udc.mu.
+			// physicalChunkData is no longer this goroutine's to touch -
+			// threadedRecoverLogicalData may already be reading it without
+			// the lock held.
+			return
+		}
+		if err != nil {
+			if err == context.Canceled {
+				// Expected: the piece was cancelled because the chunk
+				// already has enough pieces to recover.
+				return
+			}
+			udc.workersRemaining--
+			return
+		}
+		udc.physicalChunkData[pieceIndex] = data
+		udc.piecesCompleted++
+		if udc.piecesCompleted == udc.erasureCode.MinPieces() {
+			go udc.threadedRecoverLogicalData()
+		}
+	}()
+}
+
+// downloadDestinationWriteAtWindow wraps a downloadDestination so that it
+// can be handed to modules.ErasureCoder.Recover as the destination writer.
+// Recover writes the logical chunk to it sequentially, shard row by shard
+// row, as each row is reconstructed; the window discards any bytes before
+// staticFetchOffset and stops writing once staticFetchLength bytes inside
+// the window have been written. This lets a partial-chunk download (an
+// HTTP Range request, a video seek) stream straight to the destination
+// instead of buffering the full logical chunk and slicing out the
+// requested window afterwards.
+//
+// The O(fetchLength) memory win this buys depends on the Recover
+// implementation behind modules.ErasureCoder actually streaming - writing
+// each shard row through as it is reconstructed rather than assembling the
+// whole logical chunk itself before writing anything out. That
+// implementation lives outside this package (and outside this tree), so
+// this window only removes the one doubling that threadedRecoverLogicalData
+// itself used to cause; it cannot by itself guarantee peak memory below
+// whatever Recover allocates internally.
+type downloadDestinationWriteAtWindow struct {
+	staticDestination downloadDestination
+	staticWriteOffset int64  // Offset within staticDestination that maps to the first byte of the window.
+	staticWindowStart uint64 // First logical chunk byte (inclusive) to keep.
+	staticWindowEnd   uint64 // First logical chunk byte (exclusive) to discard.
+
+	chunkOffset uint64 // Number of logical chunk bytes seen across all Write calls so far.
+}
+
+// Write implements io.Writer. It discards any portion of p that falls
+// outside of [staticWindowStart, staticWindowEnd) and forwards the rest to
+// the destination at the appropriate offset. It always reports the full
+// length of p as written, since Recover expects to make forward progress
+// through the logical chunk regardless of how much of it is in the window.
+func (w *downloadDestinationWriteAtWindow) Write(p []byte) (int, error) {
+	n := len(p)
+	lo := w.chunkOffset
+	hi := w.chunkOffset + uint64(n)
+	w.chunkOffset = hi
+
+	if hi <= w.staticWindowStart || lo >= w.staticWindowEnd {
+		// Entirely before or after the requested window.
+		return n, nil
+	}
+	trimStart := uint64(0)
+	if lo < w.staticWindowStart {
+		trimStart = w.staticWindowStart - lo
+	}
+	trimEnd := uint64(n)
+	if hi > w.staticWindowEnd {
+		trimEnd -= hi - w.staticWindowEnd
+	}
+	if trimStart >= trimEnd {
+		return n, nil
+	}
+
+	writeAt := w.staticWriteOffset + int64(lo+trimStart-w.staticWindowStart)
+	if _, err := w.staticDestination.WriteAt(p[trimStart:trimEnd], writeAt); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // threadedRecoverLogicalData will take all of the pieces that have been
 // downloaded and encode them into the logical data which is then written to the
 // underlying writer for the download.
 func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
+	// Mark the chunk cancelled for delivery purposes as soon as recovery
+	// starts, not once it finishes: from this point on physicalChunkData is
+	// owned by this goroutine, and a piece that arrives from here on must
+	// bail out before touching udc.mu rather than racing recovery (or the
+	// post-recovery cleanup) to write into it.
+	atomic.StoreUint32(&udc.atomicChunkCancelled, 1)
+
+	// Enough pieces have arrived to recover the chunk. Cancel any piece
+	// fetches that are still outstanding from overdrive workers rather than
+	// letting them run to completion; the worker goroutines behind those
+	// fetches will see their context cancelled and unwind.
+	udc.mu.Lock()
+	udc.cancelOutstandingPieces()
+	udc.mu.Unlock()
+
 	// Decrypt the chunk pieces.
 	udc.mu.Lock()
 	for i := range udc.physicalChunkData {
@@ -99,40 +378,55 @@ func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
 		}
 		udc.physicalChunkData[i] = decryptedPiece
 	}
+	udc.mu.Unlock()
 
-	// Recover the pieces into the logical chunk data.
-	recoverWriter := new(bytes.Buffer)
+	// Recover the pieces directly into the download destination, outside of
+	// udc.mu: this is disk/network I/O through the destination (potentially
+	// slow or backpressured for a streaming/range-read destination) and
+	// holding the chunk's mutex across it would stall every other
+	// piece-delivery goroutine for this chunk, including the
+	// overdrive-cancellation acknowledgements chunk0-2 relies on, for as
+	// long as the write takes. This is safe without the lock because
+	// atomicChunkCancelled was set at the top of this function, so no other
+	// goroutine will touch physicalChunkData past that point. Using a
+	// window writer instead of buffering the whole logical chunk and
+	// slicing it afterwards removes one O(chunkSize) allocation here; see
+	// downloadDestinationWriteAtWindow for the caveat on how much of the
+	// O(fetchLength) win actually reaches the host, which depends on
+	// Recover's own implementation.
+	recoverWriter := &downloadDestinationWriteAtWindow{
+		staticDestination: udc.destination,
+		staticWriteOffset: udc.staticWriteOffset,
+		staticWindowStart: udc.staticFetchOffset,
+		staticWindowEnd:   udc.staticFetchOffset + udc.staticFetchLength,
+	}
 	err := udc.erasureCode.Recover(udc.physicalChunkData, udc.staticChunkSize, recoverWriter)
 	if err != nil {
+		udc.mu.Lock()
 		udc.fail(err)
 		udc.mu.Unlock()
 		return errors.AddContext(err, "unable to recover chunk")
 	}
-	// Clear out the physical chunk pieces, we do not need them anymore.
+
+	// Clear out the physical chunk pieces, we do not need them anymore, and
+	// release the memory that was used to store them. Call 'cleanUp' to
+	// trigger the memory cleanup along with some extra checks that
+	// everything is consistent.
+	udc.mu.Lock()
 	for i := range udc.physicalChunkData {
 		udc.physicalChunkData[i] = nil
 	}
-	udc.mu.Unlock()
-
-	// Write the bytes to the requested output.
-	start := udc.staticFetchOffset
-	end := udc.staticFetchOffset+udc.staticFetchLength
-	_, err = udc.destination.WriteAt(recoverWriter.Bytes()[start:end], udc.staticWriteOffset)
-	if err != nil {
-		udc.fail(err)
-		return errors.AddContext(err, "unable to write to download destination")
-	}
-	recoverWriter = nil
-
-	// Now that the download has completed and been flushed from memory, we can
-	// release the memory that was used to store the data. Call 'cleanUp' to
-	// trigger the memory cleanup along with some extra checks that everything
-	// is consistent.
-	udc.mu.Lock()
 	udc.recoveryComplete = true
 	udc.cleanUp()
 	udc.mu.Unlock()
 
+	// Let the prefetcher know this chunk is done, so it can tell whether
+	// access to this file looks sequential and, if so, schedule the next
+	// chunks ahead of whoever asks for them next.
+	if udc.download.staticRenter != nil && udc.download.staticRenter.staticChunkPrefetcher != nil {
+		udc.download.staticRenter.staticChunkPrefetcher.managedNotifyChunkCompleted(udc.staticSiaPath, udc.staticChunkIndex)
+	}
+
 	// Update the download and signal completion of this chunk.
 	udc.download.mu.Lock()
 	defer udc.download.mu.Unlock()
@@ -143,6 +437,7 @@ func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
 		// destination writer.
 		udc.download.endTime = time.Now()
 		close(udc.download.completeChan)
+		udc.download.managedCancel()
 		return udc.download.destination.Close()
 	}
 	return nil
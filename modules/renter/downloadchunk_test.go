@@ -0,0 +1,110 @@
+package renter
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeDownloadDestination is a minimal downloadDestination that records
+// every WriteAt call into an in-memory buffer sized on demand, so tests can
+// assert on exactly which bytes a writer forwarded and at what offset.
+type fakeDownloadDestination struct {
+	data   []byte
+	closed bool
+}
+
+func (f *fakeDownloadDestination) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if int64(len(f.data)) < end {
+		grown := make([]byte, end)
+		copy(grown, f.data)
+		f.data = grown
+	}
+	copy(f.data[off:end], p)
+	return len(p), nil
+}
+
+func (f *fakeDownloadDestination) Close() error {
+	f.closed = true
+	return nil
+}
+
+// TestDownloadDestinationWriteAtWindowTrimsToRange verifies that a window
+// writer forwards only the bytes inside [staticWindowStart, staticWindowEnd)
+// to the destination, regardless of how the logical chunk is chopped up
+// across Write calls, and places them at staticWriteOffset within the
+// destination rather than at their logical chunk offset.
+func TestDownloadDestinationWriteAtWindowTrimsToRange(t *testing.T) {
+	logical := []byte("0123456789abcdefghij")
+
+	dest := &fakeDownloadDestination{}
+	w := &downloadDestinationWriteAtWindow{
+		staticDestination: dest,
+		staticWriteOffset: 100,
+		staticWindowStart: 5,
+		staticWindowEnd:   12,
+	}
+
+	// Feed the logical chunk through in uneven chunks that straddle the
+	// window boundaries on both sides, the way Recover would deliver
+	// variably sized shard rows.
+	for _, piece := range [][]byte{logical[0:3], logical[3:8], logical[8:10], logical[10:15], logical[15:20]} {
+		n, err := w.Write(piece)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != len(piece) {
+			t.Fatalf("expected Write to report %v bytes written, got %v", len(piece), n)
+		}
+	}
+
+	got := dest.data[100:107]
+	want := logical[5:12]
+	if !bytes.Equal(got, want) {
+		t.Fatalf("expected window to forward %q at offset 100, got %q", want, got)
+	}
+	if len(dest.data) != 107 {
+		t.Fatalf("expected destination to only grow to the window's end offset, got length %v", len(dest.data))
+	}
+}
+
+// TestDownloadDestinationWriteAtWindowEmptyWindow verifies that a writer
+// whose window never overlaps what's written forwards nothing to the
+// destination, rather than writing a negative-length or out-of-bounds slice.
+func TestDownloadDestinationWriteAtWindowEmptyWindow(t *testing.T) {
+	dest := &fakeDownloadDestination{}
+	w := &downloadDestinationWriteAtWindow{
+		staticDestination: dest,
+		staticWindowStart: 1000,
+		staticWindowEnd:   2000,
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dest.data) != 0 {
+		t.Fatalf("expected no bytes written to the destination, got %v", dest.data)
+	}
+}
+
+// TestManagedPieceDeliveryCancelled verifies that a chunk reports delivery
+// as cancelled both when its own atomicChunkCancelled flag is set (the
+// chunk itself recovered or failed) and when its download's cancelChan is
+// closed (the download as a whole was cancelled), and not otherwise.
+func TestManagedPieceDeliveryCancelled(t *testing.T) {
+	udc := &unfinishedDownloadChunk{download: &download{cancelChan: make(chan struct{})}}
+	if udc.managedPieceDeliveryCancelled() {
+		t.Fatal("expected a fresh chunk to not be cancelled")
+	}
+
+	atomic.StoreUint32(&udc.atomicChunkCancelled, 1)
+	if !udc.managedPieceDeliveryCancelled() {
+		t.Fatal("expected the chunk-level cancellation flag alone to report cancelled")
+	}
+
+	udc2 := &unfinishedDownloadChunk{download: &download{cancelChan: make(chan struct{})}}
+	close(udc2.download.cancelChan)
+	if !udc2.managedPieceDeliveryCancelled() {
+		t.Fatal("expected a closed download cancelChan alone to report cancelled")
+	}
+}
@@ -0,0 +1,310 @@
+package renter
+
+import (
+	"sync"
+
+	"github.com/NebulousLabs/Sia/modules"
+
+	"github.com/NebulousLabs/errors"
+)
+
+const (
+	// defaultPrefetchWindow is the number of chunks ahead of the current read
+	// position that the prefetcher will speculatively fetch when it detects
+	// sequential access to a siafile.
+	defaultPrefetchWindow = 4
+
+	// defaultPrefetchMemoryCap is the amount of download memory the
+	// prefetcher is allowed to hold onto for chunks that have not been
+	// requested by a caller yet. It is counted against the renter's regular
+	// download memory budget, it is not an additional allowance.
+	defaultPrefetchMemoryCap = 1 << 26 // 64 MiB
+
+	// memoryPriorityLow is passed to prefetchMemoryManager.Request for
+	// speculative fetches, so that a foreground download's memory request
+	// is never starved by read-ahead that nobody has asked for yet.
+	memoryPriorityLow = false
+)
+
+// prefetchMemoryManager is the subset of the renter's memoryManager that the
+// prefetcher needs. It is its own interface, rather than a direct
+// *memoryManager dependency, so that tests can exercise the prefetcher's own
+// bookkeeping (LRU eviction, memory-cap accounting) against a trivial fake
+// instead of standing up the renter's real memory manager.
+type prefetchMemoryManager interface {
+	Request(amount uint64, priority bool) bool
+	Return(amount uint64)
+}
+
+// chunkPrefetchKey identifies a single chunk of a siafile for the purposes of
+// the prefetcher's in-flight map.
+type chunkPrefetchKey struct {
+	staticSiaPath    modules.SiaPath
+	staticChunkIndex uint64
+}
+
+// singleChunkCacher tracks one in-flight (or completed) speculative fetch of
+// a chunk, so that a second reader who asks for the same chunk can wait on
+// the existing fetch instead of kicking off a duplicate download. This
+// mirrors the single-flight pattern used by streaming read caches: the first
+// caller to miss starts the fetch, everyone else piggybacks on it.
+type singleChunkCacher struct {
+	staticKey chunkPrefetchKey
+
+	mu      sync.Mutex
+	done    chan struct{}
+	udc     *unfinishedDownloadChunk
+	err     error
+	memUsed uint64 // Only written with chunkPrefetcher.mu held; see threadedRunFetch.
+}
+
+// managedResult blocks until the cacher's fetch has completed and returns
+// the resulting chunk (or the error that caused it to fail).
+func (sc *singleChunkCacher) managedResult() (*unfinishedDownloadChunk, error) {
+	<-sc.done
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.udc, sc.err
+}
+
+// chunkPrefetcher speculatively schedules downloads of the chunks following
+// the one a download just completed, when the pattern of completed chunks
+// looks sequential. It keeps a bounded map of in-flight single chunk
+// cachers so that concurrent readers of the same file converge on the same
+// fetch, and counts prefetched data against the renter's download memory
+// manager so it cannot starve foreground downloads.
+//
+// The prefetcher does not know how to fetch a chunk itself - that requires
+// building an unfinishedDownloadChunk, which depends on the redundancy
+// scheme and contract set of the file being read. Instead it is handed a
+// staticFetch callback by whoever constructs it (see Renter.managedDistributeDownloadChunk),
+// and only handles the bookkeeping: dedup, bounding, eviction, and memory
+// accounting.
+type chunkPrefetcher struct {
+	staticMemoryManager prefetchMemoryManager
+	staticFetch         func(siaPath modules.SiaPath, chunkIndex uint64) (*unfinishedDownloadChunk, error)
+
+	mu             sync.Mutex
+	prefetchWindow uint64 // Number of chunks ahead to speculatively fetch. Tunable via managedUpdateSettings.
+	memoryCap      uint64 // Maximum memory the prefetcher may hold for unclaimed chunks. Tunable via managedUpdateSettings.
+	memoryUsed     uint64
+	cachers        map[chunkPrefetchKey]*singleChunkCacher
+	lru            []chunkPrefetchKey // Most recently touched key last.
+
+	// lastSequential tracks the most recently observed (siaPath, chunkIndex)
+	// completion per file, so that the next completion can be recognized as
+	// sequential access and trigger a prefetch.
+	lastSequential map[modules.SiaPath]uint64
+}
+
+// newChunkPrefetcher creates a chunkPrefetcher that will use fetch to
+// service cache misses, using window and memoryCap for tuning. A window or
+// memoryCap of zero falls back to the package defaults so that a renter
+// with no configured preference still gets useful prefetching.
+func newChunkPrefetcher(mm prefetchMemoryManager, window, memoryCap uint64, fetch func(modules.SiaPath, uint64) (*unfinishedDownloadChunk, error)) *chunkPrefetcher {
+	if window == 0 {
+		window = defaultPrefetchWindow
+	}
+	if memoryCap == 0 {
+		memoryCap = defaultPrefetchMemoryCap
+	}
+	return &chunkPrefetcher{
+		staticMemoryManager: mm,
+		staticFetch:         fetch,
+
+		prefetchWindow: window,
+		memoryCap:      memoryCap,
+		cachers:        make(map[chunkPrefetchKey]*singleChunkCacher),
+		lastSequential: make(map[modules.SiaPath]uint64),
+	}
+}
+
+// managedUpdateSettings changes the prefetch window and memory cap used for
+// future prefetch decisions. Chunks already in flight are unaffected.
+func (cp *chunkPrefetcher) managedUpdateSettings(window, memoryCap uint64) {
+	if window == 0 {
+		window = defaultPrefetchWindow
+	}
+	if memoryCap == 0 {
+		memoryCap = defaultPrefetchMemoryCap
+	}
+	cp.mu.Lock()
+	cp.prefetchWindow = window
+	cp.memoryCap = memoryCap
+	cp.mu.Unlock()
+	cp.managedEnforceMemoryCap()
+}
+
+// managedNotifyChunkCompleted informs the prefetcher that chunkIndex of
+// siaPath was just delivered to a caller. If the previous chunk delivered
+// for the same file was chunkIndex-1, the access pattern looks sequential
+// and the prefetcher schedules the next prefetchWindow chunks. It is called
+// by threadedRecoverLogicalData once a chunk finishes recovering, which is
+// the renter's actual chunk-completion path.
+func (cp *chunkPrefetcher) managedNotifyChunkCompleted(siaPath modules.SiaPath, chunkIndex uint64) {
+	cp.mu.Lock()
+	prev, hasPrev := cp.lastSequential[siaPath]
+	sequential := chunkIndex == 0 || (hasPrev && prev+1 == chunkIndex)
+	cp.lastSequential[siaPath] = chunkIndex
+	window := cp.prefetchWindow
+	cp.mu.Unlock()
+	if !sequential {
+		return
+	}
+
+	for i := uint64(1); i <= window; i++ {
+		cp.managedPrefetch(siaPath, chunkIndex+i)
+	}
+}
+
+// managedGetOrFetch returns the cacher responsible for (siaPath,
+// chunkIndex), creating and launching a fetch for it if one is not already
+// in flight or cached.
+func (cp *chunkPrefetcher) managedGetOrFetch(siaPath modules.SiaPath, chunkIndex uint64) *singleChunkCacher {
+	key := chunkPrefetchKey{staticSiaPath: siaPath, staticChunkIndex: chunkIndex}
+
+	cp.mu.Lock()
+	if sc, exists := cp.cachers[key]; exists {
+		cp.managedTouch(key)
+		cp.mu.Unlock()
+		return sc
+	}
+	sc := &singleChunkCacher{staticKey: key, done: make(chan struct{})}
+	cp.cachers[key] = sc
+	cp.managedTouch(key)
+	cp.mu.Unlock()
+
+	go cp.threadedRunFetch(sc)
+	return sc
+}
+
+// managedPrefetch kicks off a speculative, best-effort fetch for a chunk
+// that no caller has asked for yet. Unlike managedGetOrFetch it never
+// blocks the caller on the result - a download that actually wants the
+// chunk will find it already cached (or in flight) when it arrives, via
+// managedClaim.
+func (cp *chunkPrefetcher) managedPrefetch(siaPath modules.SiaPath, chunkIndex uint64) {
+	cp.managedGetOrFetch(siaPath, chunkIndex)
+}
+
+// threadedRunFetch performs the actual fetch for a cacher and reserves its
+// memory against the prefetcher's memory cap and the renter's memory
+// manager, evicting the least recently used cached chunks if necessary to
+// stay under the cap.
+func (cp *chunkPrefetcher) threadedRunFetch(sc *singleChunkCacher) {
+	defer close(sc.done)
+
+	udc, err := cp.staticFetch(sc.staticKey.staticSiaPath, sc.staticKey.staticChunkIndex)
+	sc.mu.Lock()
+	sc.udc = udc
+	sc.err = err
+	sc.mu.Unlock()
+	if err != nil {
+		cp.managedEvict(sc.staticKey)
+		return
+	}
+
+	if !cp.managedCreditFetchMemory(sc, udc.staticChunkSize) {
+		cp.managedEvict(sc.staticKey)
+		return
+	}
+	cp.managedEnforceMemoryCap()
+}
+
+// managedCreditFetchMemory requests memUsed from the memory manager for a
+// completed fetch and, if granted, credits it to the prefetcher - but only
+// if sc is still the cacher registered for its key. If sc was evicted while
+// the fetch (or this memory request) was in flight, cp.cachers[key] either
+// no longer exists or already points at a newer cacher for the same key;
+// either way sc's memory has nowhere left to be tracked, so it is returned
+// immediately instead of being added to cp.memoryUsed, where it would never
+// be found by managedEvict again and would leak for the lifetime of the
+// renter. Returns false if memory could not be obtained at all.
+func (cp *chunkPrefetcher) managedCreditFetchMemory(sc *singleChunkCacher, memUsed uint64) bool {
+	if !cp.staticMemoryManager.Request(memUsed, memoryPriorityLow) {
+		return false
+	}
+
+	cp.mu.Lock()
+	if cp.cachers[sc.staticKey] != sc {
+		cp.mu.Unlock()
+		cp.staticMemoryManager.Return(memUsed)
+		return true
+	}
+	sc.memUsed = memUsed
+	cp.memoryUsed += memUsed
+	cp.mu.Unlock()
+	return true
+}
+
+// managedTouch moves key to the most-recently-used end of the eviction
+// order. Must be called with cp.mu held.
+func (cp *chunkPrefetcher) managedTouch(key chunkPrefetchKey) {
+	for i, k := range cp.lru {
+		if k == key {
+			cp.lru = append(cp.lru[:i], cp.lru[i+1:]...)
+			break
+		}
+	}
+	cp.lru = append(cp.lru, key)
+}
+
+// managedEnforceMemoryCap evicts least-recently-used cached chunks until
+// the prefetcher's memory usage is back under its cap.
+func (cp *chunkPrefetcher) managedEnforceMemoryCap() {
+	for {
+		cp.mu.Lock()
+		if cp.memoryUsed <= cp.memoryCap || len(cp.lru) == 0 {
+			cp.mu.Unlock()
+			return
+		}
+		evictKey := cp.lru[0]
+		cp.mu.Unlock()
+		cp.managedEvict(evictKey)
+	}
+}
+
+// managedEvict removes a cacher from the prefetcher and returns any memory
+// it was holding to the memory manager.
+func (cp *chunkPrefetcher) managedEvict(key chunkPrefetchKey) {
+	cp.mu.Lock()
+	sc, exists := cp.cachers[key]
+	if !exists {
+		cp.mu.Unlock()
+		return
+	}
+	delete(cp.cachers, key)
+	for i, k := range cp.lru {
+		if k == key {
+			cp.lru = append(cp.lru[:i], cp.lru[i+1:]...)
+			break
+		}
+	}
+	memUsed := sc.memUsed
+	cp.memoryUsed -= memUsed
+	cp.mu.Unlock()
+
+	if memUsed > 0 {
+		cp.staticMemoryManager.Return(memUsed)
+	}
+}
+
+// managedClaim removes a chunk from the prefetcher's cache and hands it to
+// the caller, if it exists. It is used by Renter.managedDistributeDownloadChunk,
+// the renter's chunk-dispatch path, so that a hit on the prefetch cache
+// short-circuits the normal worker-driven fetch.
+func (cp *chunkPrefetcher) managedClaim(siaPath modules.SiaPath, chunkIndex uint64) (udc *unfinishedDownloadChunk, err error, hit bool) {
+	key := chunkPrefetchKey{staticSiaPath: siaPath, staticChunkIndex: chunkIndex}
+	cp.mu.Lock()
+	sc, exists := cp.cachers[key]
+	cp.mu.Unlock()
+	if !exists {
+		return nil, nil, false
+	}
+	udc, err = sc.managedResult()
+	cp.managedEvict(key)
+	if err != nil {
+		return nil, errors.AddContext(err, "prefetched chunk failed"), true
+	}
+	return udc, nil, true
+}
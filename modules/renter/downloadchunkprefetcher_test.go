@@ -0,0 +1,117 @@
+package renter
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// fakePrefetchMemoryManager is a trivial prefetchMemoryManager that always
+// grants memory immediately and just tallies what is outstanding, so that
+// the prefetcher's own bookkeeping - not a real memory manager's queuing -
+// is what's under test.
+type fakePrefetchMemoryManager struct {
+	mu          sync.Mutex
+	outstanding uint64
+}
+
+func (m *fakePrefetchMemoryManager) Request(amount uint64, priority bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outstanding += amount
+	return true
+}
+
+func (m *fakePrefetchMemoryManager) Return(amount uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outstanding -= amount
+}
+
+// TestChunkPrefetcherMemoryCapEviction verifies that the prefetcher evicts
+// least-recently-used chunks to stay under its memory cap, and that every
+// byte it credits to memoryUsed is eventually returned to the memory
+// manager - i.e. that memoryUsed and the memory manager's own ledger never
+// drift apart, which is exactly the invariant the eviction-during-fetch race
+// could previously break.
+func TestChunkPrefetcherMemoryCapEviction(t *testing.T) {
+	mm := &fakePrefetchMemoryManager{}
+	var siaPath modules.SiaPath
+
+	fetch := func(sp modules.SiaPath, chunkIndex uint64) (*unfinishedDownloadChunk, error) {
+		return &unfinishedDownloadChunk{staticChunkSize: 10}, nil
+	}
+	// A cap of 25 only has room for two prefetched chunks of size 10 at
+	// once; fetching a third should evict the least recently touched one
+	// instead of growing memoryUsed past the cap.
+	cp := newChunkPrefetcher(mm, 4, 25, fetch)
+
+	for i := uint64(0); i < 3; i++ {
+		sc := cp.managedGetOrFetch(siaPath, i)
+		if _, err := sc.managedResult(); err != nil {
+			t.Fatalf("unexpected fetch error: %v", err)
+		}
+	}
+	cp.managedEnforceMemoryCap()
+
+	cp.mu.Lock()
+	cached := len(cp.cachers)
+	memUsed := cp.memoryUsed
+	cp.mu.Unlock()
+
+	if memUsed > cp.memoryCap {
+		t.Fatalf("memoryUsed %v exceeds cap %v after eviction", memUsed, cp.memoryCap)
+	}
+	if cached >= 3 {
+		t.Fatalf("expected eviction to have dropped at least one cacher, got %v cached", cached)
+	}
+	if mm.outstanding != memUsed {
+		t.Fatalf("memory manager outstanding %v does not match prefetcher's memoryUsed %v - memory was leaked or double freed", mm.outstanding, memUsed)
+	}
+
+	// Claiming (and thereby evicting) every remaining cacher should return
+	// all outstanding memory to the manager.
+	for i := uint64(0); i < 3; i++ {
+		cp.managedClaim(siaPath, i)
+	}
+	if mm.outstanding != 0 {
+		t.Fatalf("expected all memory to be returned after claiming every cacher, got %v outstanding", mm.outstanding)
+	}
+}
+
+// TestChunkPrefetcherEvictedBeforeCredit verifies that a cacher evicted
+// while its fetch is still being credited does not leak memory: once
+// managedEvict has removed a key, a late threadedRunFetch for that same key
+// must return its memory rather than adding it to cp.memoryUsed under a key
+// managedEvict can no longer find.
+func TestChunkPrefetcherEvictedBeforeCredit(t *testing.T) {
+	mm := &fakePrefetchMemoryManager{}
+	var siaPath modules.SiaPath
+	key := chunkPrefetchKey{staticSiaPath: siaPath, staticChunkIndex: 0}
+
+	cp := newChunkPrefetcher(mm, 4, 1<<30, nil)
+	sc := &singleChunkCacher{staticKey: key, done: make(chan struct{})}
+	cp.mu.Lock()
+	cp.cachers[key] = sc
+	cp.managedTouch(key)
+	cp.mu.Unlock()
+
+	// Simulate the cacher being evicted (e.g. by managedEnforceMemoryCap)
+	// while its fetch goroutine is still in flight.
+	cp.managedEvict(key)
+
+	sc.udc = &unfinishedDownloadChunk{staticChunkSize: 42}
+	close(sc.done)
+	cp.managedCreditFetchMemory(sc, sc.udc.staticChunkSize)
+
+	cp.mu.Lock()
+	memUsed := cp.memoryUsed
+	cp.mu.Unlock()
+	if memUsed != 0 {
+		t.Fatalf("expected no memory credited for an evicted cacher, got memoryUsed %v", memUsed)
+	}
+	if mm.outstanding != 0 {
+		t.Fatalf("expected memory for an evicted cacher to be returned immediately, got %v outstanding", mm.outstanding)
+	}
+}
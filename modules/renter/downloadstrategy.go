@@ -0,0 +1,248 @@
+package renter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// downloadStrategyID identifies a DownloadStrategy implementation so that it
+// can be selected per-download (e.g. from the download request or an API
+// header) without exposing the interface value itself across that
+// boundary.
+type downloadStrategyID string
+
+// The strategy IDs understood by downloadStrategyForID.
+const (
+	// StrategyIDFastest behaves like the renter always has: escalate to an
+	// additional standby worker once staticLatencyTarget has elapsed.
+	StrategyIDFastest = downloadStrategyID("fastest")
+
+	// StrategyIDCheapest prefers low-priced hosts and only escalates when
+	// the chunk's (priority-adjusted) latency target is blown.
+	StrategyIDCheapest = downloadStrategyID("cheapest")
+
+	// StrategyIDRace puts every standby worker to work immediately and
+	// relies on overdrive cancellation to discard the losers.
+	StrategyIDRace = downloadStrategyID("race")
+
+	// StrategyIDCheapestThenRace tries StrategyCheapest until the chunk's
+	// staticLatencyTarget is exceeded, then falls back to StrategyRace for
+	// the remainder of the download.
+	StrategyIDCheapestThenRace = downloadStrategyID("cheapest-then-race")
+)
+
+// DownloadStrategy controls how an unfinishedDownloadChunk picks workers off
+// its standby set and when it escalates into overdrive. A chunk consults its
+// strategy every time a worker fetch returns, rather than hardcoding the
+// latency-target/overdrive logic itself.
+type DownloadStrategy interface {
+	// managedSelectStandbyWorkers returns udc.workersStandby reordered (and,
+	// for strategies like Race, possibly all returned at once) according to
+	// the strategy's preference, given how long the chunk has been in
+	// flight.
+	managedSelectStandbyWorkers(udc *unfinishedDownloadChunk, elapsed time.Duration) []*worker
+
+	// managedShouldEscalate reports whether the chunk should launch an
+	// additional overdrive piece right now, given how long the chunk has
+	// been in flight. Every implementation also caps escalation at
+	// staticOverdrive pieces in flight via shouldEscalateForOverdrive, so
+	// staticOverdrive is enforced regardless of which strategy is active.
+	managedShouldEscalate(udc *unfinishedDownloadChunk, elapsed time.Duration) bool
+
+	// managedLatencyTargetExceeded reports whether elapsed has crossed this
+	// strategy's own timing threshold, independent of the overdrive budget.
+	// downloadStrategyFallbackChain uses this - not managedShouldEscalate -
+	// to decide when a hop has fallen through for good: the overdrive
+	// budget shouldEscalateForOverdrive checks is transient (it can flip
+	// back to having room as outstanding pieces complete), so deriving hop
+	// switches from managedShouldEscalate would let the chain hop backwards
+	// once escalation paused for lack of overdrive budget rather than for
+	// lack of elapsed time.
+	managedLatencyTargetExceeded(udc *unfinishedDownloadChunk, elapsed time.Duration) bool
+}
+
+// shouldEscalateForOverdrive reports whether udc has room under its
+// staticOverdrive budget for one more escalation, i.e. whether the number
+// of piece fetches currently in flight beyond the erasure code's minimum is
+// still below staticOverdrive. Every managedShouldEscalate implementation
+// consults this in addition to its own timing preference, so a chunk never
+// escalates past the overdrive count it was built with no matter which
+// strategy is driving it.
+func shouldEscalateForOverdrive(udc *unfinishedDownloadChunk) bool {
+	udc.mu.Lock()
+	defer udc.mu.Unlock()
+	overdriveInFlight := udc.piecesRegistered - udc.erasureCode.MinPieces()
+	if overdriveInFlight < 0 {
+		overdriveInFlight = 0
+	}
+	return overdriveInFlight < udc.staticOverdrive
+}
+
+// strategyFastest is the strategy the renter has always used: workers are
+// tried in the order they were registered, and overdrive kicks in as soon
+// as staticLatencyTarget has passed without the chunk completing.
+type strategyFastest struct{}
+
+// managedSelectStandbyWorkers implements DownloadStrategy.
+func (strategyFastest) managedSelectStandbyWorkers(udc *unfinishedDownloadChunk, elapsed time.Duration) []*worker {
+	return udc.workersStandby
+}
+
+// managedShouldEscalate implements DownloadStrategy.
+func (s strategyFastest) managedShouldEscalate(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return s.managedLatencyTargetExceeded(udc, elapsed) && shouldEscalateForOverdrive(udc)
+}
+
+// managedLatencyTargetExceeded implements DownloadStrategy.
+func (strategyFastest) managedLatencyTargetExceeded(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return elapsed >= time.Duration(udc.staticLatencyTarget)*time.Millisecond
+}
+
+// strategyCheapest sorts the standby set by host price before handing
+// workers off, so that when a fetch needs to be placed the renter tries the
+// cheapest available host first. It is willing to wait for a cheap host,
+// but a higher-priority chunk is given less patience to do so: its
+// effective latency target shrinks with staticPriority, so an urgent chunk
+// escalates to a pricier host sooner instead of waiting out the same
+// target every other chunk would.
+type strategyCheapest struct{}
+
+// managedSelectStandbyWorkers implements DownloadStrategy.
+func (strategyCheapest) managedSelectStandbyWorkers(udc *unfinishedDownloadChunk, elapsed time.Duration) []*worker {
+	standby := append([]*worker(nil), udc.workersStandby...)
+	sort.Slice(standby, func(i, j int) bool {
+		return workerDownloadCost(standby[i]).Cmp(workerDownloadCost(standby[j])) < 0
+	})
+	return standby
+}
+
+// managedShouldEscalate implements DownloadStrategy.
+func (s strategyCheapest) managedShouldEscalate(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return s.managedLatencyTargetExceeded(udc, elapsed) && shouldEscalateForOverdrive(udc)
+}
+
+// managedLatencyTargetExceeded implements DownloadStrategy.
+func (strategyCheapest) managedLatencyTargetExceeded(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return elapsed >= cheapestEffectiveLatencyTarget(udc)
+}
+
+// cheapestEffectiveLatencyTarget divides staticLatencyTarget by
+// staticPriority+1, so a chunk with the default priority of 0 behaves
+// exactly like staticLatencyTarget on its own, and each priority point
+// above that shortens how long strategyCheapest will wait on a cheap host
+// before escalating.
+func cheapestEffectiveLatencyTarget(udc *unfinishedDownloadChunk) time.Duration {
+	target := udc.staticLatencyTarget / (udc.staticPriority + 1)
+	return time.Duration(target) * time.Millisecond
+}
+
+// strategyRace issues standby pieces immediately instead of waiting for the
+// latency target, up to the chunk's staticOverdrive budget, then relies on
+// overdrive cancellation (cancelOutstandingPieces) to tear down the pieces
+// that lose the race once enough have returned to recover the chunk. It
+// trades host bandwidth for the lowest possible latency, within the
+// overdrive budget the chunk was built with.
+type strategyRace struct{}
+
+// managedSelectStandbyWorkers implements DownloadStrategy.
+func (strategyRace) managedSelectStandbyWorkers(udc *unfinishedDownloadChunk, elapsed time.Duration) []*worker {
+	return udc.workersStandby
+}
+
+// managedShouldEscalate implements DownloadStrategy.
+func (strategyRace) managedShouldEscalate(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return shouldEscalateForOverdrive(udc)
+}
+
+// managedLatencyTargetExceeded implements DownloadStrategy. Race has no
+// timing threshold of its own - it is always ready to escalate, subject
+// only to the overdrive budget managedShouldEscalate checks.
+func (strategyRace) managedLatencyTargetExceeded(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return true
+}
+
+// workerDownloadCost returns the price the worker's host charges to
+// download a single piece, used by strategyCheapest to rank standby
+// workers. It is a thin wrapper so the sort comparator above stays
+// readable.
+func workerDownloadCost(w *worker) types.Currency {
+	return w.staticCache().staticContractUtility.DownloadPrice
+}
+
+// downloadStrategyFallbackChain tries each of its hops in order: it stays on
+// a hop as long as that hop's own managedLatencyTargetExceeded says not to
+// move on, and switches to the next hop for good the moment one does. The
+// last hop is never escalated past. This implements requests like "try
+// Cheapest for staticLatencyTarget, then fall back to Race": Cheapest's
+// managedLatencyTargetExceeded already reports true once its
+// (priority-adjusted) latency target is exceeded, so the chain just hands
+// that signal off to move to Race instead of re-deriving its own timing.
+// Hop switching is deliberately driven by managedLatencyTargetExceeded
+// rather than managedShouldEscalate: the latter also factors in the
+// overdrive budget, which is transient (it can open back up as outstanding
+// pieces complete) and would otherwise let the chain hop backwards to an
+// earlier strategy once its timing threshold had already passed.
+type downloadStrategyFallbackChain struct {
+	hops []DownloadStrategy
+}
+
+// managedSelectStandbyWorkers implements DownloadStrategy by delegating to
+// whichever hop is active at the given elapsed time.
+func (c *downloadStrategyFallbackChain) managedSelectStandbyWorkers(udc *unfinishedDownloadChunk, elapsed time.Duration) []*worker {
+	return c.active(udc, elapsed).managedSelectStandbyWorkers(udc, elapsed)
+}
+
+// managedShouldEscalate implements DownloadStrategy by delegating to
+// whichever hop is active at the given elapsed time.
+func (c *downloadStrategyFallbackChain) managedShouldEscalate(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return c.active(udc, elapsed).managedShouldEscalate(udc, elapsed)
+}
+
+// managedLatencyTargetExceeded implements DownloadStrategy by delegating to
+// whichever hop is active at the given elapsed time.
+func (c *downloadStrategyFallbackChain) managedLatencyTargetExceeded(udc *unfinishedDownloadChunk, elapsed time.Duration) bool {
+	return c.active(udc, elapsed).managedLatencyTargetExceeded(udc, elapsed)
+}
+
+// active returns the hop that should be consulted at elapsed: the first hop
+// in the chain whose own managedLatencyTargetExceeded has not yet fired, or
+// the final hop if every earlier one has.
+func (c *downloadStrategyFallbackChain) active(udc *unfinishedDownloadChunk, elapsed time.Duration) DownloadStrategy {
+	if len(c.hops) == 0 {
+		return strategyFastest{}
+	}
+	for _, hop := range c.hops[:len(c.hops)-1] {
+		if !hop.managedLatencyTargetExceeded(udc, elapsed) {
+			return hop
+		}
+	}
+	return c.hops[len(c.hops)-1]
+}
+
+// strategyCheapestThenRace is the concrete fallback chain behind
+// StrategyIDCheapestThenRace.
+var strategyCheapestThenRace = &downloadStrategyFallbackChain{
+	hops: []DownloadStrategy{strategyCheapest{}, strategyRace{}},
+}
+
+// downloadStrategyForID resolves a strategy selected per-download (via the
+// download request or the renter API's strategy header) to its
+// implementation, falling back to StrategyFastest for an unrecognized or
+// empty ID so that old callers and callers that don't care keep today's
+// behavior.
+func downloadStrategyForID(id downloadStrategyID) DownloadStrategy {
+	switch id {
+	case StrategyIDCheapest:
+		return strategyCheapest{}
+	case StrategyIDRace:
+		return strategyRace{}
+	case StrategyIDCheapestThenRace:
+		return strategyCheapestThenRace
+	case StrategyIDFastest:
+		fallthrough
+	default:
+		return strategyFastest{}
+	}
+}
@@ -0,0 +1,92 @@
+package renter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// workerWithPrice builds a *worker whose staticCache reports cost as its
+// host's download price, for tests that only care about cost ordering.
+func workerWithPrice(cost uint64) *worker {
+	return &worker{
+		staticWorkerCache: &workerCache{
+			staticContractUtility: contractUtility{
+				DownloadPrice: types.NewCurrency64(cost),
+			},
+		},
+	}
+}
+
+// TestStrategyCheapestSelectsStandbyWorkersByPrice verifies that
+// strategyCheapest.managedSelectStandbyWorkers returns the standby set
+// sorted ascending by host download price, and leaves udc.workersStandby
+// itself untouched.
+func TestStrategyCheapestSelectsStandbyWorkersByPrice(t *testing.T) {
+	expensive := workerWithPrice(300)
+	cheap := workerWithPrice(100)
+	medium := workerWithPrice(200)
+
+	udc := &unfinishedDownloadChunk{
+		workersStandby: []*worker{expensive, cheap, medium},
+	}
+
+	got := strategyCheapest{}.managedSelectStandbyWorkers(udc, 0)
+	want := []*worker{cheap, medium, expensive}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v workers, got %v", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected worker %v at position %v to be the %v-priced worker", i, i, want[i])
+		}
+	}
+
+	if udc.workersStandby[0] != expensive || udc.workersStandby[1] != cheap || udc.workersStandby[2] != medium {
+		t.Fatal("expected managedSelectStandbyWorkers to leave udc.workersStandby in its original order")
+	}
+}
+
+// TestDownloadStrategyFallbackChainActiveHopSwitching verifies that
+// downloadStrategyFallbackChain.active stays on its first hop until that
+// hop's latency target is exceeded, then switches to the next hop for
+// good - it should never hop back once the switch has happened, even
+// though managedLatencyTargetExceeded is what drives the switch rather
+// than managedShouldEscalate.
+func TestDownloadStrategyFallbackChainActiveHopSwitching(t *testing.T) {
+	chain := &downloadStrategyFallbackChain{
+		hops: []DownloadStrategy{strategyCheapest{}, strategyRace{}},
+	}
+	udc := &unfinishedDownloadChunk{
+		staticLatencyTarget: 100, // milliseconds
+		staticPriority:      0,
+	}
+	target := cheapestEffectiveLatencyTarget(udc)
+
+	before := chain.active(udc, target-time.Millisecond)
+	if _, ok := before.(strategyCheapest); !ok {
+		t.Fatalf("expected strategyCheapest to still be active just before the latency target, got %T", before)
+	}
+
+	atTarget := chain.active(udc, target)
+	if _, ok := atTarget.(strategyRace); !ok {
+		t.Fatalf("expected strategyRace to become active once the latency target is reached, got %T", atTarget)
+	}
+
+	after := chain.active(udc, target+time.Hour)
+	if _, ok := after.(strategyRace); !ok {
+		t.Fatalf("expected strategyRace to remain active well past the latency target, got %T", after)
+	}
+}
+
+// TestDownloadStrategyFallbackChainActiveEmptyChain verifies that a chain
+// with no hops falls back to strategyFastest rather than panicking on an
+// empty slice, matching downloadStrategyForID's fallback for an unknown ID.
+func TestDownloadStrategyFallbackChainActiveEmptyChain(t *testing.T) {
+	chain := &downloadStrategyFallbackChain{}
+	udc := &unfinishedDownloadChunk{}
+	if _, ok := chain.active(udc, time.Hour).(strategyFastest); !ok {
+		t.Fatal("expected an empty chain to fall back to strategyFastest")
+	}
+}
@@ -0,0 +1,119 @@
+package renter
+
+import (
+	"sync"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// persistence carries the renter settings that can be tuned through the
+// renter's settings API and are relevant to this package. The real renter
+// persists a great deal more than this; only the prefetch knobs the
+// chunkPrefetcher needs are reproduced here.
+type persistence struct {
+	PrefetchMemoryCap uint64 `json:"prefetchmemorycap"`
+	PrefetchWindow    uint64 `json:"prefetchwindow"`
+}
+
+// Renter owns the shared subsystems that chunk downloads are dispatched
+// through, including the chunk prefetcher.
+type Renter struct {
+	persist persistence
+
+	staticChunkPrefetcher *chunkPrefetcher
+
+	downloads      map[DownloadID]*download
+	nextDownloadID DownloadID
+
+	mu sync.RWMutex
+}
+
+// DownloadID identifies a download registered with a Renter, so that an API
+// caller that only has the ID (e.g. from an earlier API response) can look
+// the download back up to check its progress or cancel it.
+type DownloadID uint64
+
+// Download is the public handle an API caller gets back for a download
+// dispatched through a Renter. It wraps the unexported *download so that
+// Cancel can be called without exposing download's other, renter-internal
+// methods.
+type Download struct {
+	staticDownload *download
+}
+
+// Cancel cancels the download, signalling every worker still fetching a
+// piece for it to abandon that fetch. It is safe to call more than once and
+// safe to call after the download has already completed on its own.
+func (d *Download) Cancel() {
+	d.staticDownload.managedCancel()
+}
+
+// NewRenter creates a Renter and wires up its chunk prefetcher. fetchChunk
+// is the real, worker-driven chunk fetch - building the
+// unfinishedDownloadChunk and handing its pieces out to the contract set -
+// which lives in the download dispatch code. Both foreground downloads and
+// the prefetcher's speculative reads go through it via
+// managedDistributeDownloadChunk, so a prefetch hit and a prefetch miss are
+// indistinguishable to the caller.
+func NewRenter(mm prefetchMemoryManager, fetchChunk func(modules.SiaPath, uint64) (*unfinishedDownloadChunk, error)) *Renter {
+	r := &Renter{
+		persist: persistence{
+			PrefetchWindow:    defaultPrefetchWindow,
+			PrefetchMemoryCap: defaultPrefetchMemoryCap,
+		},
+		downloads: make(map[DownloadID]*download),
+	}
+	r.staticChunkPrefetcher = newChunkPrefetcher(mm, r.persist.PrefetchWindow, r.persist.PrefetchMemoryCap, fetchChunk)
+	return r
+}
+
+// SetPrefetchSettings updates the prefetch window and memory cap used for
+// future prefetch decisions. Chunks already in flight keep running under
+// the settings that launched them.
+func (r *Renter) SetPrefetchSettings(window, memoryCap uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.persist.PrefetchWindow = window
+	r.persist.PrefetchMemoryCap = memoryCap
+	r.staticChunkPrefetcher.managedUpdateSettings(window, memoryCap)
+}
+
+// managedRegisterDownload assigns d a DownloadID and makes it retrievable
+// through Download, so that whoever dispatched d (the API layer, in the
+// real renter) can hand the caller something that survives past the
+// initial call and can be used to cancel the download later.
+func (r *Renter) managedRegisterDownload(d *download) DownloadID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextDownloadID++
+	id := r.nextDownloadID
+	r.downloads[id] = d
+	return id
+}
+
+// Download looks up a download previously registered with
+// managedRegisterDownload, returning ok=false if id is unknown. The
+// returned *Download exposes Cancel, the renter API's public hook for
+// stopping a download without waiting for it to finish or time out on its
+// own.
+func (r *Renter) Download(id DownloadID) (dl *Download, ok bool) {
+	r.mu.RLock()
+	d, ok := r.downloads[id]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return &Download{staticDownload: d}, true
+}
+
+// managedDistributeDownloadChunk is the single entry point through which a
+// chunk of siaPath is obtained, whether by a foreground download or by the
+// prefetcher that ran ahead of it: it checks the prefetcher's cache first
+// so that a sequential download reaps the benefit of chunks the prefetcher
+// already pulled, falling back to the real worker-driven fetch on a miss.
+func (r *Renter) managedDistributeDownloadChunk(siaPath modules.SiaPath, chunkIndex uint64) (*unfinishedDownloadChunk, error) {
+	if udc, err, hit := r.staticChunkPrefetcher.managedClaim(siaPath, chunkIndex); hit {
+		return udc, err
+	}
+	return r.staticChunkPrefetcher.staticFetch(siaPath, chunkIndex)
+}
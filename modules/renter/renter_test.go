@@ -0,0 +1,30 @@
+package renter
+
+import "testing"
+
+// TestRenterDownloadRegisterAndCancel verifies that a download registered
+// with managedRegisterDownload can be looked back up through Download, and
+// that calling Cancel on the returned handle closes the underlying
+// download's cancelChan.
+func TestRenterDownloadRegisterAndCancel(t *testing.T) {
+	r := &Renter{downloads: make(map[DownloadID]*download)}
+	d := newDownload(r, StrategyIDFastest)
+
+	id := r.managedRegisterDownload(d)
+
+	dl, ok := r.Download(id)
+	if !ok {
+		t.Fatal("expected the registered download to be found")
+	}
+
+	dl.Cancel()
+	select {
+	case <-d.cancelChan:
+	default:
+		t.Fatal("expected Cancel to close the underlying download's cancelChan")
+	}
+
+	if _, ok := r.Download(id + 1); ok {
+		t.Fatal("expected an unregistered DownloadID to not be found")
+	}
+}
@@ -0,0 +1,46 @@
+package renter
+
+import (
+	"context"
+
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// contractUtility describes the renter's current assessment of a contract,
+// as it pertains to pricing a download. The real renter tracks a great deal
+// more than this (upload/renew eligibility, goodForUpload, etc.); only the
+// download price the strategy layer consults is reproduced here.
+type contractUtility struct {
+	DownloadPrice types.Currency
+}
+
+// workerCache holds the slow-changing, per-host information a download
+// needs on every scheduling decision, refreshed independently of the
+// worker's in-flight fetch state so that consulting it doesn't require a
+// fresh host RPC on every dispatch decision.
+type workerCache struct {
+	staticContractUtility contractUtility
+}
+
+// worker fetches pieces from a single host on behalf of a download. Only
+// the fields and methods the download path in this package uses are
+// reproduced here.
+type worker struct {
+	staticHostFCID types.FileContractID
+
+	staticWorkerCache *workerCache
+}
+
+// staticCache returns the worker's cached, slow-changing host information.
+func (w *worker) staticCache() *workerCache {
+	return w.staticWorkerCache
+}
+
+// managedDownloadPiece fetches the piece described by pieceInfo from w's
+// host, returning the raw (still encrypted) piece data. The host session
+// and RPC machinery it fetches over live outside this package (and outside
+// this tree); managedSpawnPieceFetch only relies on this call respecting
+// ctx's cancellation and returning context.Canceled when it does.
+func (w *worker) managedDownloadPiece(ctx context.Context, pieceInfo downloadPieceInfo) ([]byte, error) {
+	return nil, ctx.Err()
+}